@@ -7,15 +7,44 @@ import (
 	"bufio"
 	"bytes"
 	"io"
+	"math"
 	"strconv"
 
 	"github.com/CodisLabs/codis/pkg/utils/errors"
 )
 
 var (
-	ErrBadRespCRLFEnd  = errors.New("bad resp CRLF end")
-	ErrBadRespBytesLen = errors.New("bad resp bytes len")
-	ErrBadRespArrayLen = errors.New("bad resp array len")
+	ErrBadRespCRLFEnd   = errors.New("bad resp CRLF end")
+	ErrBadRespBytesLen  = errors.New("bad resp bytes len")
+	ErrBadRespArrayLen  = errors.New("bad resp array len")
+	ErrBadRespBool      = errors.New("bad resp bool")
+	ErrBadRespDouble    = errors.New("bad resp double")
+	ErrBadRespVerbatim  = errors.New("bad resp verbatim format")
+	ErrBadRespStreamEnd = errors.New("bad resp stream end")
+
+	ErrRespProtoNotNegotiated = errors.New("resp3 type seen without a negotiated resp3 proto")
+
+	ErrRespBulkTooLarge    = errors.New("resp bulk bytes too large")
+	ErrRespArrayTooLarge   = errors.New("resp array too large")
+	ErrRespTooDeep         = errors.New("resp nesting too deep")
+	ErrRespTooManyElements = errors.New("resp has too many elements")
+	ErrRespLineTooLong     = errors.New("resp line too long")
+
+	ErrBadRespInlineQuoting = errors.New("bad resp inline command quoting")
+)
+
+// Default resource limits applied by a zero-value Decoder, chosen to
+// comfortably fit any real command/reply while rejecting the hostile
+// lengths a malicious client can claim (e.g. "*2147483647\r\n") before a
+// single byte is allocated for them. Proxy configs that need bigger
+// bulk values (large SET/MSET payloads) should raise MaxBulkLen
+// explicitly on their Decoder rather than rely on these defaults.
+const (
+	DefaultMaxBulkLen       = 512 * 1024 * 1024 // redis proto-max-bulk-len
+	DefaultMaxArrayLen      = 1024 * 1024
+	DefaultMaxDepth         = 32
+	DefaultMaxTotalElements = 4 * 1024 * 1024
+	DefaultMaxLineLen       = 64 * 1024
 )
 
 func btoi(b []byte) (int64, error) {
@@ -49,13 +78,31 @@ func btoi(b []byte) (int64, error) {
 	}
 }
 
-//解码结构体
+// 解码结构体
+// Proto selects which protocol the peer has negotiated. It defaults to
+// ProtoRESP2; callers that complete a RESP3 `HELLO 3` handshake should set
+// it to ProtoRESP3 so decodeResp accepts RESP3-only first-byte tags
+// instead of treating them as a protocol error.
 type Decoder struct {
 	*bufio.Reader
-	Err error
+	Err   error
+	Proto ProtoVersion
+
+	// MaxBulkLen, MaxArrayLen, MaxDepth and MaxTotalElements bound the
+	// resources a single Decode call may claim, protecting the proxy
+	// against a hostile payload such as "*2147483647\r\n" or deeply
+	// nested arrays. Zero means "use the corresponding Default* constant";
+	// a negative value disables that particular check.
+	MaxBulkLen       int64
+	MaxArrayLen      int64
+	MaxDepth         int
+	MaxTotalElements int64
+
+	elements int64 // running total for the in-flight top-level Decode
+	pool     *RespPool
 }
 
-//构造函数
+// 构造函数
 func NewDecoder(br *bufio.Reader) *Decoder {
 	return &Decoder{Reader: br}
 }
@@ -68,11 +115,20 @@ func NewDecoderSize(r io.Reader, size int) *Decoder {
 	return &Decoder{Reader: br}
 }
 
-//解码函数
+// NewDecoderSize3 is a convenience constructor for a peer that has already
+// negotiated RESP3 via `HELLO 3`.
+func NewDecoderSize3(r io.Reader, size int) *Decoder {
+	d := NewDecoderSize(r, size)
+	d.Proto = ProtoRESP3
+	return d
+}
+
+// 解码函数
 func (d *Decoder) Decode() (*Resp, error) {
 	if d.Err != nil {
 		return nil, d.Err
 	}
+	d.elements = 0
 	r, err := d.decodeResp(0)
 	if err != nil {
 		d.Err = err
@@ -80,18 +136,80 @@ func (d *Decoder) Decode() (*Resp, error) {
 	return r, err
 }
 
-//从bufio.Reader读取数据
+func (d *Decoder) maxBulkLen() int64 {
+	switch {
+	case d.MaxBulkLen < 0:
+		return -1
+	case d.MaxBulkLen == 0:
+		return DefaultMaxBulkLen
+	default:
+		return d.MaxBulkLen
+	}
+}
+
+func (d *Decoder) maxArrayLen() int64 {
+	switch {
+	case d.MaxArrayLen < 0:
+		return -1
+	case d.MaxArrayLen == 0:
+		return DefaultMaxArrayLen
+	default:
+		return d.MaxArrayLen
+	}
+}
+
+func (d *Decoder) maxDepth() int {
+	switch {
+	case d.MaxDepth < 0:
+		return -1
+	case d.MaxDepth == 0:
+		return DefaultMaxDepth
+	default:
+		return d.MaxDepth
+	}
+}
+
+func (d *Decoder) maxTotalElements() int64 {
+	switch {
+	case d.MaxTotalElements < 0:
+		return -1
+	case d.MaxTotalElements == 0:
+		return DefaultMaxTotalElements
+	default:
+		return d.MaxTotalElements
+	}
+}
+
+// checkElement accounts for one more decoded element against
+// MaxTotalElements, aggregated across the whole top-level Decode call.
+func (d *Decoder) checkElement() error {
+	if max := d.maxTotalElements(); max >= 0 {
+		d.elements++
+		if d.elements > max {
+			return errors.Trace(ErrRespTooManyElements)
+		}
+	}
+	return nil
+}
+
+// 从bufio.Reader读取数据
 func Decode(br *bufio.Reader) (*Resp, error) {
 	return NewDecoder(br).Decode()
 }
 
-//从字符串数组中读取数据，将字符串数组转换为bufio.Reader
+// 从字符串数组中读取数据，将字符串数组转换为bufio.Reader
 func DecodeFromBytes(p []byte) (*Resp, error) {
 	return Decode(bufio.NewReader(bytes.NewReader(p)))
 }
 
-//解码函数
+// 解码函数
 func (d *Decoder) decodeResp(depth int) (*Resp, error) {
+	if max := d.maxDepth(); max >= 0 && depth > max {
+		return nil, errors.Trace(ErrRespTooDeep)
+	}
+	if err := d.checkElement(); err != nil {
+		return nil, err
+	}
 	b, err := d.ReadByte()
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -108,18 +226,62 @@ func (d *Decoder) decodeResp(depth int) (*Resp, error) {
 	//	(*) 表示消息体总共有多少行，不包括当前行,*后面是具体的行数。
 	//	($) 表示下一行数据长度，不包括换行符长度\r\n,$后面则是对应的长度的数据。
 	//	(:) 表示返回一个数值，：后面是相应的数字节符。
-	switch t := RespType(b); t {
+	//RESP3在此基础上新增了以下几种类型（需要客户端通过HELLO 3协商后使用）：
+	//	(_) Null，(#) Boolean(t/f)，(,) Double，(() Big number，(=) Verbatim string，
+	//	(%) Map，(~) Set，(>) Push，以及以长度"?"开头、以独立的"."元素结束的流式聚合类型。
+	t := RespType(b)
+	// RESP3-only tags are only valid once the peer has negotiated RESP3
+	// via HELLO 3 (reflected by d.Proto); on a plain RESP2 connection one
+	// of these bytes can only mean a desynced/corrupt stream.
+	if d.Proto != ProtoRESP3 && isResp3OnlyType(t) {
+		return nil, errors.Trace(ErrRespProtoNotNegotiated)
+	}
+	switch t {
 	case TypeString, TypeError, TypeInt:
-		r := &Resp{Type: t}
+		r := d.newResp(t)
 		r.Value, err = d.decodeTextBytes()
 		return r, err
 	case TypeBulkBytes:
-		r := &Resp{Type: t}
+		r := d.newResp(t)
 		r.Value, err = d.decodeBulkBytes()
 		return r, err
 	case TypeArray:
-		r := &Resp{Type: t}
-		r.Array, err = d.decodeArray(depth)
+		r := d.newResp(t)
+		r.Array, err = d.decodeAggregate(depth, false)
+		return r, err
+	case TypeNull:
+		if _, err := d.decodeTextBytes(); err != nil {
+			return nil, err
+		}
+		return d.newResp(t), nil
+	case TypeBool:
+		r := d.newResp(t)
+		r.Bool, err = d.decodeBool()
+		return r, err
+	case TypeDouble:
+		r := d.newResp(t)
+		r.Double, err = d.decodeDouble()
+		return r, err
+	case TypeBigNumber:
+		r := d.newResp(t)
+		r.BigNumber, err = d.decodeTextString()
+		return r, err
+	case TypeVerbatim:
+		r := d.newResp(t)
+		r.Verbatim, r.Value, err = d.decodeVerbatim()
+		return r, err
+	case TypeMap:
+		r := d.newResp(t)
+		r.Map, err = d.decodeAggregate(depth, true)
+		return r, err
+	case TypeSet:
+		r := d.newResp(t)
+		r.Array, err = d.decodeAggregate(depth, false)
+		return r, err
+	case TypePush:
+		r := d.newResp(t)
+		r.IsPush = true
+		r.Array, err = d.decodeAggregate(depth, false)
 		return r, err
 	default:
 		if depth != 0 {
@@ -134,15 +296,50 @@ func (d *Decoder) decodeResp(depth int) (*Resp, error) {
 	}
 }
 
+// isResp3OnlyType reports whether t is one of the types RESP3 added over
+// RESP2 (Null/Bool/Double/BigNumber/Verbatim/Map/Set/Push), i.e. the ones
+// gated behind Decoder.Proto == ProtoRESP3.
+func isResp3OnlyType(t RespType) bool {
+	switch t {
+	case TypeNull, TypeBool, TypeDouble, TypeBigNumber, TypeVerbatim, TypeMap, TypeSet, TypePush:
+		return true
+	default:
+		return false
+	}
+}
+
+// decodeTextBytes reads a single CRLF-terminated line, accumulating at
+// most DefaultMaxLineLen bytes before giving up -- without this cap a
+// hostile peer that never sends '\n' can make the read grow without
+// bound. ReadSlice returns a slice aliasing bufio.Reader's internal
+// buffer that is only valid until the next read, so (unlike ReadBytes)
+// it must be copied into owned memory before it is returned: callers
+// keep the Value around (as a TypeString/TypeError/TypeInt Resp, an
+// inline-command token, or a Resp queued on ParseStream's channel) well
+// past the next buffer refill.
 func (d *Decoder) decodeTextBytes() ([]byte, error) {
-	b, err := d.ReadBytes('\n')
-	if err != nil {
-		return nil, errors.Trace(err)
+	var line []byte
+	for {
+		chunk, err := d.ReadSlice('\n')
+		if err != bufio.ErrBufferFull {
+			line = append(line, chunk...)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			break
+		}
+		line = append(line, chunk...)
+		if int64(len(line)) > DefaultMaxLineLen {
+			return nil, errors.Trace(ErrRespLineTooLong)
+		}
+	}
+	if int64(len(line)) > DefaultMaxLineLen {
+		return nil, errors.Trace(ErrRespLineTooLong)
 	}
-	if n := len(b) - 2; n < 0 || b[n] != '\r' {
+	if n := len(line) - 2; n < 0 || line[n] != '\r' {
 		return nil, errors.Trace(ErrBadRespCRLFEnd)
 	} else {
-		return b[:n], nil
+		return line[:n], nil
 	}
 }
 
@@ -172,7 +369,10 @@ func (d *Decoder) decodeBulkBytes() ([]byte, error) {
 	} else if n == -1 {
 		return nil, nil
 	}
-	b := make([]byte, n+2)
+	if max := d.maxBulkLen(); max >= 0 && n > max {
+		return nil, errors.Trace(ErrRespBulkTooLarge)
+	}
+	b := d.getBulkBuf(n + 2)
 	if _, err := io.ReadFull(d.Reader, b); err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -182,8 +382,21 @@ func (d *Decoder) decodeBulkBytes() ([]byte, error) {
 	return b[:n], nil
 }
 
-func (d *Decoder) decodeArray(depth int) ([]*Resp, error) {
-	n, err := d.decodeInt()
+// decodeAggregate decodes the body of an Array/Map/Set/Push, all of which
+// share the same "<length><elements>" wire shape. When pairs is true
+// (TypeMap), the declared length N covers N key/value pairs, i.e. 2N
+// following elements. A length of "?" (RESP3 streamed aggregate) reads
+// elements until a standalone "." terminator element is seen instead of a
+// fixed count.
+func (d *Decoder) decodeAggregate(depth int, pairs bool) ([]*Resp, error) {
+	b, err := d.decodeTextBytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 1 && b[0] == '?' {
+		return d.decodeStreamedAggregate(depth)
+	}
+	n, err := btoi(b)
 	if err != nil {
 		return nil, err
 	}
@@ -192,7 +405,26 @@ func (d *Decoder) decodeArray(depth int) ([]*Resp, error) {
 	} else if n == -1 {
 		return nil, nil
 	}
-	a := make([]*Resp, n)
+	max := d.maxArrayLen()
+	if pairs {
+		// Bounds-check before doubling: a hostile peer can claim a pair
+		// count close to MaxInt64/2, which would overflow back to a
+		// negative length once doubled and slip past the post-doubling
+		// check below straight into make([]*Resp, <negative>). This must
+		// hold even with MaxArrayLen set to -1 (checks disabled), so it's
+		// checked unconditionally rather than folded into the max>=0 case.
+		if n > math.MaxInt64/2 {
+			return nil, errors.Trace(ErrRespArrayTooLarge)
+		}
+		if max >= 0 && n > max/2 {
+			return nil, errors.Trace(ErrRespArrayTooLarge)
+		}
+		n *= 2
+	}
+	if max >= 0 && n > max {
+		return nil, errors.Trace(ErrRespArrayTooLarge)
+	}
+	a := d.getArrayBuf(n)
 	for i := 0; i < len(a); i++ {
 		if a[i], err = d.decodeResp(depth + 1); err != nil {
 			return nil, err
@@ -201,22 +433,266 @@ func (d *Decoder) decodeArray(depth int) ([]*Resp, error) {
 	return a, nil
 }
 
+func (d *Decoder) decodeStreamedAggregate(depth int) ([]*Resp, error) {
+	a := make([]*Resp, 0, 4)
+	for {
+		b, err := d.Peek(1)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if b[0] == '.' {
+			if _, err := d.ReadByte(); err != nil {
+				return nil, errors.Trace(err)
+			}
+			if _, err := d.decodeTextBytes(); err != nil {
+				return nil, errors.Trace(ErrBadRespStreamEnd)
+			}
+			return a, nil
+		}
+		r, err := d.decodeResp(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, r)
+	}
+}
+
+func (d *Decoder) decodeBool() (bool, error) {
+	b, err := d.decodeTextBytes()
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case len(b) == 1 && b[0] == 't':
+		return true, nil
+	case len(b) == 1 && b[0] == 'f':
+		return false, nil
+	default:
+		return false, errors.Trace(ErrBadRespBool)
+	}
+}
+
+func (d *Decoder) decodeDouble() (float64, error) {
+	s, err := d.decodeTextString()
+	if err != nil {
+		return 0, err
+	}
+	switch s {
+	case "inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan":
+		return math.NaN(), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, errors.Trace(ErrBadRespDouble)
+	}
+	return f, nil
+}
+
+func (d *Decoder) decodeVerbatim() (string, []byte, error) {
+	b, err := d.decodeBulkBytes()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(b) < 4 || b[3] != ':' {
+		return "", nil, errors.Trace(ErrBadRespVerbatim)
+	}
+	return string(b[:3]), b[4:], nil
+}
+
 func (d *Decoder) decodeSingleLineBulkBytesArray() ([]*Resp, error) {
 	b, err := d.decodeTextBytes()
 	if err != nil {
 		return nil, err
 	}
-	a := make([]*Resp, 0, 4)
+	tokens, err := splitInlineCommand(b)
+	if err != nil {
+		return nil, err
+	}
+	a := d.getArrayBuf(int64(len(tokens)))
+	for i, t := range tokens {
+		r := d.newResp(TypeBulkBytes)
+		r.Value = d.ownInlineToken(t)
+		a[i] = r
+	}
+	return a, nil
+}
+
+// ownInlineToken returns a copy of t suitable for storing on a pooled
+// Resp.Value. A token from splitInlineCommand without escapes is a
+// sub-slice of the one shared line buffer decodeTextBytes read, so handing one to
+// r.Release() as-is would return overlapping memory to the bulk pool
+// under the assumption that it's independently owned, corrupting
+// whichever other Resp's Value is later handed the same bytes. An
+// unpooled Decoder has no such contract to uphold, so it keeps aliasing
+// the line buffer as before.
+func (d *Decoder) ownInlineToken(t []byte) []byte {
+	if d.pool == nil {
+		return t
+	}
+	b := d.getBulkBuf(int64(len(t)))
+	copy(b, t)
+	return b
+}
+
+// splitInlineCommand tokenizes an inline command line the same way real
+// Redis does: tokens are separated by runs of space/tab, a double-quoted
+// token may contain spaces and C-style escapes (\n \r \t \b \a \\ \" and
+// \xHH), and a single-quoted token may contain spaces with only \' and \\
+// recognized as escapes. This lets operators talk to codis-proxy with
+// plain telnet/nc the same way they can talk to Redis directly.
+func splitInlineCommand(b []byte) ([][]byte, error) {
+	if bytes.IndexByte(b, '"') < 0 && bytes.IndexByte(b, '\'') < 0 {
+		return splitInlineCommandFast(b), nil
+	}
+	var tokens [][]byte
+	i := 0
+	for {
+		for i < len(b) && isInlineSpace(b[i]) {
+			i++
+		}
+		if i == len(b) {
+			break
+		}
+		var tok []byte
+		switch {
+		case b[i] == '"':
+			i++
+			start := i
+			for {
+				if i >= len(b) {
+					return nil, errors.Trace(ErrBadRespInlineQuoting)
+				}
+				if b[i] == '"' {
+					break
+				}
+				if b[i] == '\\' && i+1 < len(b) {
+					tok = append(tok, b[start:i]...)
+					esc, n, ok := decodeInlineEscape(b[i+1:])
+					if !ok {
+						return nil, errors.Trace(ErrBadRespInlineQuoting)
+					}
+					tok = append(tok, esc...)
+					i += 1 + n
+					start = i
+					continue
+				}
+				i++
+			}
+			if tok != nil {
+				tok = append(tok, b[start:i]...)
+			} else {
+				tok = b[start:i]
+			}
+			i++ // skip closing quote
+			if i < len(b) && !isInlineSpace(b[i]) {
+				return nil, errors.Trace(ErrBadRespInlineQuoting)
+			}
+		case b[i] == '\'':
+			i++
+			start := i
+			for {
+				if i >= len(b) {
+					return nil, errors.Trace(ErrBadRespInlineQuoting)
+				}
+				if b[i] == '\'' {
+					break
+				}
+				if b[i] == '\\' && i+1 < len(b) && (b[i+1] == '\'' || b[i+1] == '\\') {
+					tok = append(tok, b[start:i]...)
+					tok = append(tok, b[i+1])
+					i += 2
+					start = i
+					continue
+				}
+				i++
+			}
+			if tok != nil {
+				tok = append(tok, b[start:i]...)
+			} else {
+				tok = b[start:i]
+			}
+			i++ // skip closing quote
+			if i < len(b) && !isInlineSpace(b[i]) {
+				return nil, errors.Trace(ErrBadRespInlineQuoting)
+			}
+		default:
+			start := i
+			for i < len(b) && !isInlineSpace(b[i]) {
+				i++
+			}
+			tok = b[start:i]
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, nil
+}
+
+func splitInlineCommandFast(b []byte) [][]byte {
+	a := make([][]byte, 0, 4)
 	for l, r := 0, 0; r <= len(b); r++ {
-		if r == len(b) || b[r] == ' ' {
+		if r == len(b) || isInlineSpace(b[r]) {
 			if l < r {
-				a = append(a, &Resp{
-					Type:  TypeBulkBytes,
-					Value: b[l:r],
-				})
+				a = append(a, b[l:r])
 			}
 			l = r + 1
 		}
 	}
-	return a, nil
+	return a
+}
+
+func isInlineSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// decodeInlineEscape decodes the escape sequence starting right after the
+// backslash in a double-quoted inline token (b does not include the
+// backslash itself). It returns the decoded bytes, the number of bytes of
+// b consumed, and whether the escape was recognized.
+func decodeInlineEscape(b []byte) ([]byte, int, bool) {
+	switch b[0] {
+	case 'n':
+		return []byte{'\n'}, 1, true
+	case 'r':
+		return []byte{'\r'}, 1, true
+	case 't':
+		return []byte{'\t'}, 1, true
+	case 'b':
+		return []byte{'\b'}, 1, true
+	case 'a':
+		return []byte{'\a'}, 1, true
+	case '\\':
+		return []byte{'\\'}, 1, true
+	case '"':
+		return []byte{'"'}, 1, true
+	case 'x':
+		if len(b) >= 3 && isHexDigit(b[1]) && isHexDigit(b[2]) {
+			return []byte{hexDigitsToByte(b[1], b[2])}, 3, true
+		}
+		return nil, 0, false
+	default:
+		return nil, 0, false
+	}
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexDigitValue(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func hexDigitsToByte(hi, lo byte) byte {
+	return hexDigitValue(hi)<<4 | hexDigitValue(lo)
 }
@@ -0,0 +1,174 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func decodeString(t *testing.T, s string) (*Resp, error) {
+	return DecodeFromBytes([]byte(s))
+}
+
+// decodeString3 is decodeString for a peer that has negotiated RESP3, so
+// RESP3-only type tags are accepted instead of rejected.
+func decodeString3(t *testing.T, s string) (*Resp, error) {
+	d := NewDecoderSize3(bytes.NewReader([]byte(s)), len(s))
+	return d.Decode()
+}
+
+func TestDecodeLimitsRejectHugeBulkLen(t *testing.T) {
+	_, err := decodeString(t, "$2147483647\r\n")
+	if err == nil {
+		t.Fatal("expected ErrRespBulkTooLarge")
+	}
+}
+
+func TestDecodeLimitsRejectHugeArrayLen(t *testing.T) {
+	_, err := decodeString(t, "*2147483647\r\n")
+	if err == nil {
+		t.Fatal("expected ErrRespArrayTooLarge")
+	}
+}
+
+func TestDecodeLimitsRejectDeepNesting(t *testing.T) {
+	var buf bytes.Buffer
+	for i := 0; i < DefaultMaxDepth+2; i++ {
+		buf.WriteString("*1\r\n")
+	}
+	buf.WriteString("$3\r\nfoo\r\n")
+	d := NewDecoder(bufio.NewReader(&buf))
+	if _, err := d.Decode(); err == nil {
+		t.Fatal("expected ErrRespTooDeep")
+	}
+}
+
+func TestDecodeLimitsRejectTooManyElements(t *testing.T) {
+	d := NewDecoder(bufio.NewReader(bytes.NewReader([]byte("*4\r\n:1\r\n:2\r\n:3\r\n:4\r\n"))))
+	d.MaxTotalElements = 3
+	if _, err := d.Decode(); err == nil {
+		t.Fatal("expected ErrRespTooManyElements")
+	}
+}
+
+func TestDecodeLimitsAreConfigurable(t *testing.T) {
+	d := NewDecoder(bufio.NewReader(bytes.NewReader([]byte("*2\r\n:1\r\n:2\r\n"))))
+	d.MaxArrayLen = 1
+	if _, err := d.Decode(); err == nil {
+		t.Fatal("expected ErrRespArrayTooLarge with custom limit")
+	}
+}
+
+func TestDecodeLimitsCanBeDisabled(t *testing.T) {
+	d := NewDecoder(bufio.NewReader(bytes.NewReader([]byte("*2\r\n:1\r\n:2\r\n"))))
+	d.MaxArrayLen = -1
+	r, err := d.Decode()
+	if err != nil || len(r.Array) != 2 {
+		t.Fatalf("expected array of 2, got %+v, err=%v", r, err)
+	}
+}
+
+func TestDecodeResp3Types(t *testing.T) {
+	tests := []struct {
+		in   string
+		want RespType
+	}{
+		{"_\r\n", TypeNull},
+		{"#t\r\n", TypeBool},
+		{"#f\r\n", TypeBool},
+		{",3.14\r\n", TypeDouble},
+		{"(3492890328409238509324850943850943825024385\r\n", TypeBigNumber},
+		{"=15\r\ntxt:Some string\r\n", TypeVerbatim},
+		{"%1\r\n+key\r\n:1\r\n", TypeMap},
+		{"~2\r\n+a\r\n+b\r\n", TypeSet},
+		{">2\r\n+message\r\n+hello\r\n", TypePush},
+	}
+	for _, tt := range tests {
+		r, err := decodeString3(t, tt.in)
+		if err != nil {
+			t.Fatalf("decode(%q) = %v", tt.in, err)
+		}
+		if r.Type != tt.want {
+			t.Fatalf("decode(%q) type = %v, want %v", tt.in, r.Type, tt.want)
+		}
+	}
+}
+
+func TestDecodeResp3StreamedAggregate(t *testing.T) {
+	r, err := decodeString(t, "*?\r\n:1\r\n:2\r\n.\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Array) != 2 {
+		t.Fatalf("expected 2 elements, got %+v", r.Array)
+	}
+}
+
+func TestDecodeResp3PushIsFlagged(t *testing.T) {
+	r, err := decodeString3(t, ">1\r\n+hello\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.IsPush {
+		t.Fatal("expected IsPush to be true")
+	}
+}
+
+func TestDecodeRejectsResp3TypesWithoutNegotiation(t *testing.T) {
+	for _, in := range []string{
+		"_\r\n",
+		"#t\r\n",
+		",3.14\r\n",
+		"(123\r\n",
+		"=5\r\ntxt:x\r\n",
+		"%1\r\n+key\r\n:1\r\n",
+		"~1\r\n+a\r\n",
+		">1\r\n+hello\r\n",
+	} {
+		if _, err := decodeString(t, in); err != ErrRespProtoNotNegotiated {
+			t.Fatalf("decode(%q) = %v, want ErrRespProtoNotNegotiated", in, err)
+		}
+	}
+}
+
+func TestDecodeLimitsRejectHugeMapLenWithoutOverflow(t *testing.T) {
+	// 2^62 pairs: doubled for the key/value count this would overflow
+	// int64 to a negative length if checked after doubling instead of
+	// before.
+	_, err := decodeString3(t, "%4611686018427387904\r\n")
+	if err == nil {
+		t.Fatal("expected ErrRespArrayTooLarge")
+	}
+}
+
+func TestDecodeRejectsHugeMapLenOverflowWithLimitsDisabled(t *testing.T) {
+	// Same overflow as TestDecodeLimitsRejectHugeMapLenWithoutOverflow, but
+	// with MaxArrayLen disabled: the pre-doubling overflow guard must not
+	// be skipped just because the max>=0 size check is.
+	d := NewDecoder(bufio.NewReader(bytes.NewReader([]byte("%4611686018427387904\r\n"))))
+	d.Proto = ProtoRESP3
+	d.MaxArrayLen = -1
+	if _, err := d.Decode(); err == nil {
+		t.Fatal("expected ErrRespArrayTooLarge")
+	}
+}
+
+func TestDecodeTextBytesDoesNotAliasReaderBuffer(t *testing.T) {
+	// A bufio.Reader small enough that the second reply's read refills
+	// the buffer the first reply's Value would alias if decodeTextBytes
+	// returned a slice into it instead of a copy.
+	d := NewDecoderSize(bytes.NewReader([]byte("+AAAAAAA\r\n+BBBBBBB\r\n")), 16)
+	first, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if string(first.Value) != "AAAAAAA" {
+		t.Fatalf("first.Value corrupted by second Decode: got %q", first.Value)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// Encoder encodes a *Resp tree into RESP bytes. Proto selects which wire
+// version is emitted: RESP3-only types are downgraded to their closest
+// RESP2 equivalent when Proto is ProtoRESP2 (the default zero value), so
+// callers that haven't seen a client's `HELLO 3` can keep replying in a
+// protocol that client understands.
+//
+// This only covers the wire format. Teaching request/response routing
+// and the multi-key command splitter to negotiate HELLO and carry Proto
+// per-session is the proxy layer's job; that layer isn't part of this
+// package and is out of scope here.
+type Encoder struct {
+	*bufio.Writer
+	Err   error
+	Proto ProtoVersion
+}
+
+type ProtoVersion int
+
+const (
+	ProtoRESP2 ProtoVersion = iota
+	ProtoRESP3
+)
+
+func NewEncoder(w *bufio.Writer) *Encoder {
+	return &Encoder{Writer: w}
+}
+
+func NewEncoderSize(w io.Writer, size int) *Encoder {
+	bw, ok := w.(*bufio.Writer)
+	if !ok {
+		bw = bufio.NewWriterSize(w, size)
+	}
+	return &Encoder{Writer: bw}
+}
+
+func (e *Encoder) Encode(r *Resp, flush bool) error {
+	if e.Err != nil {
+		return e.Err
+	}
+	if err := e.encodeResp(r); err != nil {
+		e.Err = err
+	} else if flush {
+		if err := e.Flush(); err != nil {
+			e.Err = errors.Trace(err)
+		}
+	}
+	return e.Err
+}
+
+func Encode(w *bufio.Writer, r *Resp) error {
+	return NewEncoder(w).Encode(r, true)
+}
+
+// EncodeAndRelease encodes r, then releases it back to its RespPool (a
+// no-op if r wasn't pooled). Use this on the proxy's reply path once a
+// pooled *Resp has been written out and nothing will read it again.
+func (e *Encoder) EncodeAndRelease(r *Resp, flush bool) error {
+	err := e.Encode(r, flush)
+	r.Release()
+	return err
+}
+
+func (e *Encoder) encodeResp(r *Resp) error {
+	if e.Proto != ProtoRESP3 {
+		r = downgradeToRESP2(r)
+	}
+	switch r.Type {
+	case TypeString, TypeError, TypeInt:
+		if err := e.WriteByte(byte(r.Type)); err != nil {
+			return errors.Trace(err)
+		}
+		return e.encodeTextBytes(r.Value)
+	case TypeBulkBytes:
+		return e.encodeBulkBytes(r.Value)
+	case TypeArray:
+		return e.encodeArray(r.Array)
+	case TypeNull:
+		return e.encodeRawString("_\r\n")
+	case TypeBool:
+		if r.Bool {
+			return e.encodeRawString("#t\r\n")
+		}
+		return e.encodeRawString("#f\r\n")
+	case TypeDouble:
+		return e.encodeDouble(r.Double)
+	case TypeBigNumber:
+		return e.encodeRawLine('(', []byte(r.BigNumber))
+	case TypeVerbatim:
+		return e.encodeVerbatim(r.Verbatim, r.Value)
+	case TypeMap:
+		return e.encodeMap(r.Map)
+	case TypeSet:
+		return e.encodeTyped('~', r.Array)
+	case TypePush:
+		return e.encodeTyped('>', r.Array)
+	default:
+		return errors.Errorf("bad resp type %s", r.Type)
+	}
+}
+
+func (e *Encoder) encodeTextBytes(b []byte) error {
+	if _, err := e.Write(b); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := e.WriteString("\r\n"); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func (e *Encoder) encodeRawLine(tag byte, b []byte) error {
+	if err := e.WriteByte(tag); err != nil {
+		return errors.Trace(err)
+	}
+	return e.encodeTextBytes(b)
+}
+
+func (e *Encoder) encodeRawString(s string) error {
+	if _, err := e.WriteString(s); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func (e *Encoder) encodeInt(n int64) error {
+	return e.encodeTextBytes(strconv.AppendInt(nil, n, 10))
+}
+
+func (e *Encoder) encodeBulkBytes(b []byte) error {
+	if b == nil {
+		return e.encodeRawString("$-1\r\n")
+	}
+	if err := e.WriteByte('$'); err != nil {
+		return errors.Trace(err)
+	}
+	if err := e.encodeInt(int64(len(b))); err != nil {
+		return err
+	}
+	return e.encodeTextBytes(b)
+}
+
+func (e *Encoder) encodeArray(array []*Resp) error {
+	return e.encodeTyped('*', array)
+}
+
+func (e *Encoder) encodeTyped(tag byte, array []*Resp) error {
+	if array == nil {
+		if err := e.WriteByte(tag); err != nil {
+			return errors.Trace(err)
+		}
+		return e.encodeRawString("-1\r\n")
+	}
+	if err := e.WriteByte(tag); err != nil {
+		return errors.Trace(err)
+	}
+	if err := e.encodeInt(int64(len(array))); err != nil {
+		return err
+	}
+	for _, r := range array {
+		if err := e.encodeResp(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) encodeMap(pairs []*Resp) error {
+	if pairs == nil {
+		return e.encodeRawString("%-1\r\n")
+	}
+	if err := e.WriteByte('%'); err != nil {
+		return errors.Trace(err)
+	}
+	if err := e.encodeInt(int64(len(pairs) / 2)); err != nil {
+		return err
+	}
+	for _, r := range pairs {
+		if err := e.encodeResp(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) encodeDouble(f float64) error {
+	return e.encodeRawLine(',', []byte(formatDouble(f)))
+}
+
+// formatDouble renders f the way RESP3 peers emit and expect doubles:
+// lowercase "inf"/"-inf"/"nan" rather than Go's "+Inf"/"-Inf"/"NaN".
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+func (e *Encoder) encodeVerbatim(format string, b []byte) error {
+	if err := e.WriteByte('='); err != nil {
+		return errors.Trace(err)
+	}
+	if err := e.encodeInt(int64(len(format) + 1 + len(b))); err != nil {
+		return err
+	}
+	if _, err := e.WriteString(format); err != nil {
+		return errors.Trace(err)
+	}
+	if err := e.WriteByte(':'); err != nil {
+		return errors.Trace(err)
+	}
+	return e.encodeTextBytes(b)
+}
+
+// downgradeToRESP2 maps a RESP3-only reply onto its closest RESP2
+// equivalent, for clients that never sent `HELLO 3`.
+func downgradeToRESP2(r *Resp) *Resp {
+	switch r.Type {
+	case TypeNull:
+		return &Resp{Type: TypeBulkBytes, Value: nil}
+	case TypeBool:
+		if r.Bool {
+			return &Resp{Type: TypeInt, Value: []byte("1")}
+		}
+		return &Resp{Type: TypeInt, Value: []byte("0")}
+	case TypeDouble:
+		return &Resp{Type: TypeBulkBytes, Value: []byte(formatDouble(r.Double))}
+	case TypeBigNumber:
+		return &Resp{Type: TypeBulkBytes, Value: []byte(r.BigNumber)}
+	case TypeVerbatim:
+		return &Resp{Type: TypeBulkBytes, Value: r.Value}
+	case TypeMap:
+		return &Resp{Type: TypeArray, Array: r.Map}
+	case TypeSet, TypePush:
+		return &Resp{Type: TypeArray, Array: r.Array}
+	default:
+		return r
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"testing"
+)
+
+func encodeToString(t *testing.T, proto ProtoVersion, r *Resp) string {
+	var buf bytes.Buffer
+	e := NewEncoder(bufio.NewWriter(&buf))
+	e.Proto = proto
+	if err := e.Encode(r, true); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return buf.String()
+}
+
+func TestEncodeDowngradesRESP3ForRESP2Clients(t *testing.T) {
+	tests := []struct {
+		name string
+		r    *Resp
+		want string
+	}{
+		{"null", NewNull(), "$-1\r\n"},
+		{"bool true", NewBool(true), ":1\r\n"},
+		{"bool false", NewBool(false), ":0\r\n"},
+		{"double", NewDouble(3.5), "$3\r\n3.5\r\n"},
+		{"map", NewMap([]*Resp{NewString([]byte("a")), NewInt([]byte("1"))}), "*2\r\n+a\r\n:1\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := encodeToString(t, ProtoRESP2, tt.r)
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeKeepsRESP3ForRESP3Clients(t *testing.T) {
+	got := encodeToString(t, ProtoRESP3, NewBool(true))
+	if got != "#t\r\n" {
+		t.Fatalf("got %q, want %q", got, "#t\r\n")
+	}
+}
+
+func TestEncodeDoubleUsesLowercaseInfNan(t *testing.T) {
+	tests := []struct {
+		f    float64
+		want string
+	}{
+		{math.Inf(1), ",inf\r\n"},
+		{math.Inf(-1), ",-inf\r\n"},
+		{math.NaN(), ",nan\r\n"},
+	}
+	for _, tt := range tests {
+		got := encodeToString(t, ProtoRESP3, NewDouble(tt.f))
+		if got != tt.want {
+			t.Fatalf("encode(%v) = %q, want %q", tt.f, got, tt.want)
+		}
+	}
+}
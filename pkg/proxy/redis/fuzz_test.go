@@ -0,0 +1,308 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"testing"
+)
+
+// FuzzDecode feeds arbitrary bytes into Decode and asserts only that it
+// never panics -- any input, however malformed, must come back as either
+// a *Resp or an error.
+func FuzzDecode(f *testing.F) {
+	for _, seed := range []string{
+		"+OK\r\n",
+		"-ERR bad\r\n",
+		":1000\r\n",
+		"$6\r\nfoobar\r\n",
+		"$-1\r\n",
+		"*-1\r\n",
+		"*0\r\n",
+		"*2\r\n$3\r\nfoo\r\n$3\r\nbar\r\n",
+		"_\r\n",
+		"#t\r\n",
+		",3.14\r\n",
+		"(123\r\n",
+		"=15\r\ntxt:Some string\r\n",
+		"%1\r\n+key\r\n:1\r\n",
+		"~2\r\n+a\r\n+b\r\n",
+		">2\r\n+message\r\n+hello\r\n",
+		"*?\r\n:1\r\n.\r\n",
+		"SET foo bar\r\n",
+		"*2147483647\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := NewDecoder(bufio.NewReader(bytes.NewReader(data)))
+		d.MaxArrayLen = 1024
+		d.MaxBulkLen = 1 << 20
+		d.MaxDepth = 32
+		d.MaxTotalElements = 1 << 16
+		_, _ = d.Decode()
+	})
+}
+
+// FuzzRoundtrip generates (derives from the fuzz input) a *Resp tree,
+// encodes it, decodes the result, and asserts the decoded tree is
+// structurally equal to the original -- i.e. Encode/Decode are inverses.
+func FuzzRoundtrip(f *testing.F) {
+	f.Add([]byte{0x00, 0x01, 0x02, 0x03})
+	f.Add([]byte{0x2a, 0xff, 0x00, 0x10, 0x20})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := respFromFuzzBytes(data)
+
+		var buf bytes.Buffer
+		e := NewEncoder(bufio.NewWriter(&buf))
+		e.Proto = ProtoRESP3 // avoid the intentional RESP2 downgrade, see TestEncodeDowngradesRESP3ForRESP2Clients
+		if err := e.Encode(r, true); err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+
+		d := NewDecoder(bufio.NewReader(bytes.NewReader(buf.Bytes())))
+		d.Proto = ProtoRESP3 // wire bytes above are RESP3, see e.Proto above
+		got, err := d.Decode()
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if !respEqual(r, got) {
+			t.Fatalf("roundtrip mismatch:\n  want=%+v\n  got= %+v", r, got)
+		}
+	})
+}
+
+// respFromFuzzBytes deterministically builds a *Resp tree out of
+// arbitrary fuzz bytes, using a byte at a time to pick a type/shape so
+// the corpus can still explore the tree-shaped input space.
+func respFromFuzzBytes(data []byte) *Resp {
+	return respFromFuzzBytesDepth(data, 0)
+}
+
+func respFromFuzzBytesDepth(data []byte, depth int) *Resp {
+	if len(data) == 0 {
+		return NewBulkBytes(nil)
+	}
+	tag, rest := data[0], data[1:]
+	if depth >= 4 {
+		return NewBulkBytes(rest)
+	}
+	switch tag % 13 {
+	case 0:
+		return NewString(stripCRLF(rest))
+	case 1:
+		return NewError(stripCRLF(rest))
+	case 2:
+		return NewInt([]byte("1234"))
+	case 3:
+		return NewBulkBytes(rest)
+	case 4:
+		if len(rest) == 0 {
+			return NewArray(nil)
+		}
+		mid := len(rest) / 2
+		return NewArray([]*Resp{
+			respFromFuzzBytesDepth(rest[:mid], depth+1),
+			respFromFuzzBytesDepth(rest[mid:], depth+1),
+		})
+	case 5:
+		return NewBool(len(rest)%2 == 0)
+	case 6:
+		return NewNull()
+	case 7:
+		return NewDouble(3.5)
+	case 8:
+		return NewBigNumber("3492890328409238509324850943850943825024385")
+	case 9:
+		return NewVerbatim("txt", stripCRLF(rest))
+	case 10:
+		if len(rest) < 2 {
+			return NewMap(nil)
+		}
+		mid := len(rest) / 2
+		return NewMap([]*Resp{
+			respFromFuzzBytesDepth(rest[:mid], depth+1),
+			respFromFuzzBytesDepth(rest[mid:], depth+1),
+		})
+	case 11:
+		if len(rest) == 0 {
+			return NewSet(nil)
+		}
+		mid := len(rest) / 2
+		return NewSet([]*Resp{
+			respFromFuzzBytesDepth(rest[:mid], depth+1),
+			respFromFuzzBytesDepth(rest[mid:], depth+1),
+		})
+	default:
+		if len(rest) == 0 {
+			return NewPush(nil)
+		}
+		mid := len(rest) / 2
+		return NewPush([]*Resp{
+			respFromFuzzBytesDepth(rest[:mid], depth+1),
+			respFromFuzzBytesDepth(rest[mid:], depth+1),
+		})
+	}
+}
+
+// stripCRLF removes \r and \n from b: TypeString/TypeError are
+// line-oriented (terminated by the first bare CRLF), unlike the
+// binary-safe TypeBulkBytes, so they can't carry those bytes as payload.
+func stripCRLF(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c != '\r' && c != '\n' {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func respEqual(a, b *Resp) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type != b.Type {
+		return false
+	}
+	switch a.Type {
+	case TypeString, TypeError, TypeBulkBytes:
+		return bytes.Equal(a.Value, b.Value)
+	case TypeInt:
+		return bytes.Equal(a.Value, b.Value)
+	case TypeBool:
+		return a.Bool == b.Bool
+	case TypeNull:
+		return true
+	case TypeDouble:
+		return a.Double == b.Double
+	case TypeBigNumber:
+		return a.BigNumber == b.BigNumber
+	case TypeVerbatim:
+		return a.Verbatim == b.Verbatim && bytes.Equal(a.Value, b.Value)
+	case TypeArray, TypeSet, TypePush:
+		if len(a.Array) != len(b.Array) {
+			return false
+		}
+		for i := range a.Array {
+			if !respEqual(a.Array[i], b.Array[i]) {
+				return false
+			}
+		}
+		return true
+	case TypeMap:
+		if len(a.Map) != len(b.Map) {
+			return false
+		}
+		for i := range a.Map {
+			if !respEqual(a.Map[i], b.Map[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return true
+	}
+}
+
+// conformance suite: table-driven edge cases the RESP spec calls out
+// explicitly, exercising btoi/decodeTextBytes/decodeBulkBytes/decodeAggregate.
+
+func TestConformanceNullAndEmpty(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		fn   func(*testing.T, *Resp)
+	}{
+		{"null bulk", "$-1\r\n", func(t *testing.T, r *Resp) {
+			if r.Type != TypeBulkBytes || r.Value != nil {
+				t.Fatalf("got %+v", r)
+			}
+		}},
+		{"null array", "*-1\r\n", func(t *testing.T, r *Resp) {
+			if r.Type != TypeArray || r.Array != nil {
+				t.Fatalf("got %+v", r)
+			}
+		}},
+		{"empty bulk", "$0\r\n\r\n", func(t *testing.T, r *Resp) {
+			if r.Type != TypeBulkBytes || len(r.Value) != 0 || r.Value == nil {
+				t.Fatalf("got %+v", r)
+			}
+		}},
+		{"empty array", "*0\r\n", func(t *testing.T, r *Resp) {
+			if r.Type != TypeArray || len(r.Array) != 0 || r.Array == nil {
+				t.Fatalf("got %+v", r)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := DecodeFromBytes([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("decode(%q): %v", tt.in, err)
+			}
+			tt.fn(t, r)
+		})
+	}
+}
+
+func TestConformanceBinarySafety(t *testing.T) {
+	payload := []byte("a\r\nb\rc\nd")
+	line := append([]byte("$8\r\n"), payload...)
+	line = append(line, '\r', '\n')
+	r, err := DecodeFromBytes(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Value, payload) {
+		t.Fatalf("got %q, want %q", r.Value, payload)
+	}
+}
+
+func TestConformanceIntegerBoundaries(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{":9223372036854775807\r\n", math.MaxInt64},
+		{":-9223372036854775808\r\n", math.MinInt64},
+		{":+123\r\n", 123},
+		{":0123\r\n", 123},
+		{":0\r\n", 0},
+	}
+	for _, tt := range tests {
+		r, err := DecodeFromBytes([]byte(tt.in))
+		if err != nil {
+			t.Fatalf("decode(%q): %v", tt.in, err)
+		}
+		n, err := btoi(r.Value)
+		if err != nil {
+			t.Fatalf("btoi(%q): %v", r.Value, err)
+		}
+		if n != tt.want {
+			t.Fatalf("decode(%q) = %d, want %d", tt.in, n, tt.want)
+		}
+	}
+}
+
+func TestConformanceMalformedFrames(t *testing.T) {
+	tests := []string{
+		"$3\r\nfoo",    // missing trailing CRLF
+		"$3\nfoo\r\n",  // missing CR before LF on the length line
+		"*-2\r\n",      // negative length other than -1
+		"$-2\r\n",      // negative length other than -1
+		"$abc\r\n\r\n", // non-digit length
+		"*abc\r\n",     // non-digit length
+	}
+	for _, in := range tests {
+		if _, err := DecodeFromBytes([]byte(in)); err == nil {
+			t.Fatalf("decode(%q): expected error, got nil", in)
+		}
+	}
+	if _, err := btoi([]byte("notanumber")); err == nil {
+		t.Fatal("btoi(notanumber): expected error, got nil")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+import (
+	"testing"
+)
+
+func decodeInline(t *testing.T, line string) []string {
+	r, err := DecodeFromBytes([]byte(line + "\r\n"))
+	if err != nil {
+		t.Fatalf("decode(%q): %v", line, err)
+	}
+	if r.Type != TypeArray {
+		t.Fatalf("decode(%q): type = %v, want array", line, r.Type)
+	}
+	out := make([]string, len(r.Array))
+	for i, e := range r.Array {
+		out[i] = string(e.Value)
+	}
+	return out
+}
+
+func TestInlineCommandFastPath(t *testing.T) {
+	got := decodeInline(t, "SET foo bar")
+	want := []string{"SET", "foo", "bar"}
+	if !equalStrings(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestInlineCommandTabSeparator(t *testing.T) {
+	got := decodeInline(t, "SET\tfoo\tbar")
+	want := []string{"SET", "foo", "bar"}
+	if !equalStrings(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestInlineCommandDoubleQuoted(t *testing.T) {
+	got := decodeInline(t, `SET foo "hello world"`)
+	want := []string{"SET", "foo", "hello world"}
+	if !equalStrings(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestInlineCommandDoubleQuotedEscapes(t *testing.T) {
+	got := decodeInline(t, `SET foo "a b\r\nc\x41"`)
+	want := []string{"SET", "foo", "a b\r\nc\x41"}
+	if !equalStrings(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestInlineCommandSingleQuoted(t *testing.T) {
+	got := decodeInline(t, `SET foo 'hello \'world\''`)
+	want := []string{"SET", "foo", "hello 'world'"}
+	if !equalStrings(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestInlineCommandUnterminatedQuoteIsError(t *testing.T) {
+	_, err := DecodeFromBytes([]byte("SET foo \"unterminated\r\n"))
+	if err == nil {
+		t.Fatal("expected ErrBadRespInlineQuoting")
+	}
+}
+
+func TestInlineCommandTrailingCharsAfterQuoteIsError(t *testing.T) {
+	_, err := DecodeFromBytes([]byte(`SET "foo"bar` + "\r\n"))
+	if err == nil {
+		t.Fatal("expected ErrBadRespInlineQuoting")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
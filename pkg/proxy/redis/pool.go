@@ -0,0 +1,130 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+import (
+	"io"
+	"sync"
+)
+
+// RespPool holds the sync.Pools backing a pooled Decoder's bulk buffers
+// and *Resp/[]*Resp values. A busy proxy decodes one *Resp tree per
+// request and discards it almost immediately after routing, so reusing
+// these across requests removes the dominant allocation source on that
+// hot path. Share one RespPool across every Decoder on a connection (or
+// across all connections); it is safe for concurrent use.
+type RespPool struct {
+	bulk  sync.Pool
+	resp  sync.Pool
+	array sync.Pool
+}
+
+func NewRespPool() *RespPool {
+	return &RespPool{
+		bulk:  sync.Pool{New: func() interface{} { return make([]byte, 0, 64) }},
+		resp:  sync.Pool{New: func() interface{} { return new(Resp) }},
+		array: sync.Pool{New: func() interface{} { return make([]*Resp, 0, 8) }},
+	}
+}
+
+func (p *RespPool) getBulk(n int) []byte {
+	b := p.bulk.Get().([]byte)
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
+
+func (p *RespPool) putBulk(b []byte) {
+	if b != nil {
+		p.bulk.Put(b[:0])
+	}
+}
+
+func (p *RespPool) getArray(n int) []*Resp {
+	a := p.array.Get().([]*Resp)
+	if cap(a) < n {
+		return make([]*Resp, n)
+	}
+	a = a[:n]
+	for i := range a {
+		a[i] = nil
+	}
+	return a
+}
+
+func (p *RespPool) putArray(a []*Resp) {
+	if a != nil {
+		p.array.Put(a[:0])
+	}
+}
+
+func (p *RespPool) getResp(t RespType) *Resp {
+	r := p.resp.Get().(*Resp)
+	*r = Resp{Type: t, pool: p}
+	return r
+}
+
+func (p *RespPool) putResp(r *Resp) {
+	*r = Resp{}
+	p.resp.Put(r)
+}
+
+// WithPool returns d with its *Resp/[]*Resp/bulk-buffer allocations drawn
+// from pool instead of make(). Callers that only need to inspect a
+// decoded request (e.g. to compute a slot/route) should call Release on
+// the result once done with it; callers that forward the *Resp as-is
+// (e.g. unmodified to a backend) should leave it unreleased.
+func (d *Decoder) WithPool(pool *RespPool) *Decoder {
+	d.pool = pool
+	return d
+}
+
+// NewPooledDecoderSize is NewDecoderSize followed by WithPool(pool).
+func NewPooledDecoderSize(r io.Reader, size int, pool *RespPool) *Decoder {
+	return NewDecoderSize(r, size).WithPool(pool)
+}
+
+func (d *Decoder) newResp(t RespType) *Resp {
+	if d.pool != nil {
+		return d.pool.getResp(t)
+	}
+	return &Resp{Type: t}
+}
+
+func (d *Decoder) getBulkBuf(n int64) []byte {
+	if d.pool != nil {
+		return d.pool.getBulk(int(n))
+	}
+	return make([]byte, n)
+}
+
+func (d *Decoder) getArrayBuf(n int64) []*Resp {
+	if d.pool != nil {
+		return d.pool.getArray(int(n))
+	}
+	return make([]*Resp, n)
+}
+
+// Release returns r, and everything it owns, to the RespPool it was
+// allocated from. It is a no-op for a *Resp decoded without a pool.
+// Release must not be called more than once on the same tree, and the
+// tree must not be read again afterwards.
+func (r *Resp) Release() {
+	if r == nil || r.pool == nil {
+		return
+	}
+	pool := r.pool
+	array, mp, bulk := r.Array, r.Map, r.Value
+	for _, e := range array {
+		e.Release()
+	}
+	for _, e := range mp {
+		e.Release()
+	}
+	pool.putBulk(bulk)
+	pool.putArray(array)
+	pool.putArray(mp)
+	pool.putResp(r)
+}
@@ -0,0 +1,135 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestPooledDecodeReleaseReusesBuffers(t *testing.T) {
+	pool := NewRespPool()
+	payload := []byte("*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n")
+
+	d := NewPooledDecoderSize(bytes.NewReader(payload), 4096, pool)
+	r, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Array) != 3 || string(r.Array[0].Value) != "SET" {
+		t.Fatalf("unexpected decode: %+v", r)
+	}
+	r.Release()
+
+	// Decoding again should reuse the buffers just released rather than
+	// allocate fresh ones; we can't observe pool hits directly, but a
+	// second decode+release must still round-trip cleanly.
+	d2 := NewPooledDecoderSize(bytes.NewReader(payload), 4096, pool)
+	r2, err := d2.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r2.Array[2].Value) != "bar" {
+		t.Fatalf("unexpected decode: %+v", r2)
+	}
+	r2.Release()
+}
+
+func TestPooledInlineCommandTokensDoNotAliasSharedLineBuffer(t *testing.T) {
+	pool := NewRespPool()
+	d := NewPooledDecoderSize(bytes.NewReader([]byte("SET foo bar\r\n")), 4096, pool)
+	r, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Array) != 3 {
+		t.Fatalf("unexpected decode: %+v", r)
+	}
+	cmd, foo, bar := r.Array[0], r.Array[1], r.Array[2]
+	cmd.Release()
+	// foo and bar must still read correctly: if cmd's Value had been a
+	// sub-slice of the same line buffer as foo/bar, releasing it back to
+	// the bulk pool (and a later getBulk reusing that backing array) would
+	// silently corrupt foo/bar too.
+	if string(foo.Value) != "foo" || string(bar.Value) != "bar" {
+		t.Fatalf("token corrupted after releasing a sibling: foo=%q bar=%q", foo.Value, bar.Value)
+	}
+	// Were cmd.Value a sub-slice of the same backing array as foo/bar, with
+	// enough capacity left to cover them, a pooled bulk decode that reuses
+	// the buffer cmd.Release() just returned would overwrite that shared
+	// backing array and corrupt foo/bar even though neither was released.
+	d2 := NewPooledDecoderSize(bytes.NewReader([]byte("$9\r\nCLOBBERED\r\n")), 4096, pool)
+	if _, err := d2.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if string(foo.Value) != "foo" || string(bar.Value) != "bar" {
+		t.Fatalf("token corrupted by a later pooled bulk decode: foo=%q bar=%q", foo.Value, bar.Value)
+	}
+}
+
+func TestReleaseOnUnpooledRespIsNoop(t *testing.T) {
+	r, err := DecodeFromBytes([]byte("$3\r\nfoo\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Release() // must not panic
+}
+
+var benchPool = NewRespPool()
+
+func benchPayloads() map[string][]byte {
+	return map[string][]byte{
+		"SET":  []byte("*3\r\n$3\r\nSET\r\n$16\r\nsome-cache-key-1\r\n$5\r\nhello\r\n"),
+		"GET":  []byte("*2\r\n$3\r\nGET\r\n$16\r\nsome-cache-key-1\r\n"),
+		"MGET": []byte("*4\r\n$4\r\nMGET\r\n$16\r\nsome-cache-key-1\r\n$16\r\nsome-cache-key-2\r\n$16\r\nsome-cache-key-3\r\n"),
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for name, payload := range benchPayloads() {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := DecodeFromBytes(payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecodePooled(b *testing.B) {
+	for name, payload := range benchPayloads() {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				d := NewPooledDecoderSize(bytes.NewReader(payload), len(payload), benchPool)
+				r, err := d.Decode()
+				if err != nil {
+					b.Fatal(err)
+				}
+				r.Release()
+			}
+		})
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	r := NewArray([]*Resp{
+		NewBulkBytes([]byte("SET")),
+		NewBulkBytes([]byte("some-cache-key-1")),
+		NewBulkBytes([]byte("hello")),
+	})
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		bw.Reset(&buf)
+		if err := Encode(bw, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+// RespType is the first byte of a RESP frame, identifying its payload kind.
+type RespType byte
+
+const (
+	TypeString    RespType = '+'
+	TypeError     RespType = '-'
+	TypeInt       RespType = ':'
+	TypeBulkBytes RespType = '$'
+	TypeArray     RespType = '*'
+
+	// RESP3 types, see https://github.com/antirez/RESP3
+	TypeNull      RespType = '_'
+	TypeBool      RespType = '#'
+	TypeDouble    RespType = ','
+	TypeBigNumber RespType = '('
+	TypeVerbatim  RespType = '='
+	TypeMap       RespType = '%'
+	TypeSet       RespType = '~'
+	TypePush      RespType = '>'
+)
+
+func (t RespType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeError:
+		return "error"
+	case TypeInt:
+		return "int"
+	case TypeBulkBytes:
+		return "bulkbytes"
+	case TypeArray:
+		return "array"
+	case TypeNull:
+		return "null"
+	case TypeBool:
+		return "bool"
+	case TypeDouble:
+		return "double"
+	case TypeBigNumber:
+		return "bignumber"
+	case TypeVerbatim:
+		return "verbatim"
+	case TypeMap:
+		return "map"
+	case TypeSet:
+		return "set"
+	case TypePush:
+		return "push"
+	default:
+		return "unknown"
+	}
+}
+
+// Resp is a decoded RESP value. Only the fields relevant to Type are
+// populated; the rest are left at their zero value.
+//
+// RESP3 adds a handful of types that don't map onto the RESP2 shapes:
+//   - Map holds alternating key/value *Resp pairs for TypeMap.
+//   - Double/Bool hold the decoded scalar for TypeDouble/TypeBool.
+//   - BigNumber holds the arbitrary-precision integer as its decimal string.
+//   - Verbatim holds the 3-byte format tag (e.g. "txt", "mkd") separately
+//     from Value, which holds the payload.
+//   - Array is reused for TypeSet and TypePush as well as TypeArray: they
+//     share the same wire shape, and IsPush distinguishes an out-of-band
+//     push message from a plain array/set reply.
+type Resp struct {
+	Type RespType
+
+	Value []byte
+	Array []*Resp
+	Map   []*Resp
+
+	Double    float64
+	Bool      bool
+	BigNumber string
+	Verbatim  string
+
+	IsPush bool
+
+	pool *RespPool // non-nil if this Resp was drawn from a pool; see Release
+}
+
+func NewString(value []byte) *Resp {
+	return &Resp{Type: TypeString, Value: value}
+}
+
+func NewError(value []byte) *Resp {
+	return &Resp{Type: TypeError, Value: value}
+}
+
+func NewInt(value []byte) *Resp {
+	return &Resp{Type: TypeInt, Value: value}
+}
+
+func NewBulkBytes(value []byte) *Resp {
+	return &Resp{Type: TypeBulkBytes, Value: value}
+}
+
+func NewArray(array []*Resp) *Resp {
+	return &Resp{Type: TypeArray, Array: array}
+}
+
+func NewNull() *Resp {
+	return &Resp{Type: TypeNull}
+}
+
+func NewBool(value bool) *Resp {
+	return &Resp{Type: TypeBool, Bool: value}
+}
+
+func NewDouble(value float64) *Resp {
+	return &Resp{Type: TypeDouble, Double: value}
+}
+
+func NewBigNumber(value string) *Resp {
+	return &Resp{Type: TypeBigNumber, BigNumber: value}
+}
+
+func NewVerbatim(format string, value []byte) *Resp {
+	return &Resp{Type: TypeVerbatim, Verbatim: format, Value: value}
+}
+
+func NewMap(pairs []*Resp) *Resp {
+	return &Resp{Type: TypeMap, Map: pairs}
+}
+
+func NewSet(array []*Resp) *Resp {
+	return &Resp{Type: TypeSet, Array: array}
+}
+
+func NewPush(array []*Resp) *Resp {
+	return &Resp{Type: TypePush, Array: array, IsPush: true}
+}
+
+func (r *Resp) IsString() bool {
+	return r.Type == TypeString
+}
+
+func (r *Resp) IsError() bool {
+	return r.Type == TypeError
+}
+
+func (r *Resp) IsInt() bool {
+	return r.Type == TypeInt
+}
+
+func (r *Resp) IsBulkBytes() bool {
+	return r.Type == TypeBulkBytes
+}
+
+func (r *Resp) IsArray() bool {
+	return r.Type == TypeArray
+}
+
+func (r *Resp) IsNull() bool {
+	return r.Type == TypeNull || ((r.Type == TypeBulkBytes || r.Type == TypeArray) && r.Value == nil && r.Array == nil)
+}
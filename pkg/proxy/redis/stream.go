@@ -0,0 +1,99 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+import (
+	"io"
+)
+
+// Payload is one item produced by ParseStream: either a decoded Resp, or
+// the terminal error that ended the stream (io.EOF on a clean close).
+type Payload struct {
+	Resp *Resp
+	Err  error
+}
+
+// ParseStream starts a goroutine that owns a bufio.Reader over r and
+// decodes one RESP value at a time, forwarding each as a Payload on the
+// returned channel. This lets callers drive a full-duplex connection from
+// a select loop instead of blocking on a synchronous Decode call. The
+// channel is closed after the first error is sent (io.EOF on a clean
+// close, or the protocol error that broke framing); callers should stop
+// reading from the channel once they observe a non-nil Payload.Err.
+//
+// done lets a caller that stops draining the channel early (e.g. on
+// connection shutdown) reclaim the goroutine: closing done unblocks a
+// pending send and ends the goroutine instead of leaving it parked
+// forever on a full channel. Pass nil if the caller always drains the
+// channel to its close.
+func ParseStream(r io.Reader, done <-chan struct{}) <-chan Payload {
+	return ParseStreamSize(r, defaultBufSize, done)
+}
+
+// ParseStreamSize is ParseStream with an explicit bufio.Reader size.
+func ParseStreamSize(r io.Reader, size int, done <-chan struct{}) <-chan Payload {
+	ch := make(chan Payload, 128)
+	d := NewDecoderSize(r, size)
+	go func() {
+		defer close(ch)
+		for {
+			resp, err := d.Decode()
+			select {
+			case ch <- Payload{Resp: resp, Err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+const defaultBufSize = 4096
+
+// WriteStream consumes *Resp values from in and encodes them to w,
+// coalescing bufio.Writer.Flush calls: a Resp is flushed immediately only
+// once in has no more values buffered, so a burst of pipelined replies is
+// written with a single flush instead of one per reply. WriteStream
+// returns when in is closed or encoding fails; the first encoding error is
+// sent on the returned error channel before it is closed.
+//
+// done lets a caller abandon the stream without closing in: closing done
+// ends the goroutine instead of leaving it parked on a receive from in
+// that will never come.
+func WriteStream(w io.Writer, in <-chan *Resp, done <-chan struct{}) <-chan error {
+	return WriteStreamSize(w, in, defaultBufSize, done)
+}
+
+// WriteStreamSize is WriteStream with an explicit bufio.Writer size.
+func WriteStreamSize(w io.Writer, in <-chan *Resp, size int, done <-chan struct{}) <-chan error {
+	errs := make(chan error, 1)
+	e := NewEncoderSize(w, size)
+	go func() {
+		defer close(errs)
+		for {
+			select {
+			case r, ok := <-in:
+				if !ok {
+					if e.Buffered() > 0 {
+						if err := e.Flush(); err != nil {
+							errs <- err
+						}
+					}
+					return
+				}
+				flush := len(in) == 0
+				if err := e.Encode(r, flush); err != nil {
+					errs <- err
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return errs
+}
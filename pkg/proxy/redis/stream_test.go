@@ -0,0 +1,138 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package redis
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseStreamDecodesUntilCleanEOF(t *testing.T) {
+	r := bytes.NewReader([]byte("+OK\r\n:1\r\n"))
+	ch := ParseStream(r, nil)
+
+	p1 := <-ch
+	if p1.Err != nil || p1.Resp.Type != TypeString {
+		t.Fatalf("first payload: %+v", p1)
+	}
+	p2 := <-ch
+	if p2.Err != nil || p2.Resp.Type != TypeInt {
+		t.Fatalf("second payload: %+v", p2)
+	}
+	p3 := <-ch
+	if p3.Err != io.EOF {
+		t.Fatalf("expected io.EOF, got %+v", p3)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("channel should be closed after the terminal error")
+	}
+}
+
+func TestParseStreamClosesChannelAfterProtocolError(t *testing.T) {
+	r := bytes.NewReader([]byte("+OK\r\nbogus\n"))
+	ch := ParseStream(r, nil)
+
+	p1 := <-ch
+	if p1.Err != nil || p1.Resp.Type != TypeString {
+		t.Fatalf("first payload: %+v", p1)
+	}
+	p2 := <-ch
+	if p2.Err == nil {
+		t.Fatal("expected a protocol error")
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("channel should be closed after the terminal error")
+	}
+}
+
+func TestParseStreamStopsOnDoneWhenConsumerStopsDraining(t *testing.T) {
+	// Enough replies to fill the channel's buffer and force the producer
+	// goroutine to block on the send; without done it would park there
+	// forever once the test stops reading from ch.
+	var buf bytes.Buffer
+	for i := 0; i < 256; i++ {
+		buf.WriteString("+OK\r\n")
+	}
+
+	done := make(chan struct{})
+	ch := ParseStreamSize(&buf, defaultBufSize, done)
+	time.Sleep(50 * time.Millisecond) // let the producer fill the buffer and block on a send
+	close(done)
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return // closed: the producer goroutine has exited
+			}
+		case <-deadline:
+			t.Fatal("ParseStream goroutine did not stop after done was closed")
+		}
+	}
+}
+
+func TestWriteStreamFlushesOnClose(t *testing.T) {
+	var buf bytes.Buffer
+	in := make(chan *Resp, 2)
+	in <- NewString([]byte("OK"))
+	close(in)
+
+	errs := WriteStream(&buf, in, nil)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "+OK\r\n" {
+		t.Fatalf("got %q", buf.String())
+	}
+}
+
+// countingWriter counts the Write calls that reach it, i.e. the flushes
+// of the bufio.Writer in front of it.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestWriteStreamCoalescesFlushesForPipelinedReplies(t *testing.T) {
+	var buf countingWriter
+	in := make(chan *Resp, 2)
+	in <- NewInt([]byte("1"))
+	in <- NewInt([]byte("2"))
+	close(in)
+
+	errs := WriteStream(&buf, in, nil)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != ":1\r\n:2\r\n" {
+		t.Fatalf("got %q", buf.String())
+	}
+	if buf.writes != 1 {
+		t.Fatalf("expected both replies in 1 flush, got %d writes", buf.writes)
+	}
+}
+
+func TestWriteStreamStopsOnDone(t *testing.T) {
+	in := make(chan *Resp) // never sent to, never closed
+	done := make(chan struct{})
+	errs := WriteStream(io.Discard, in, done)
+	close(done)
+
+	select {
+	case _, ok := <-errs:
+		if ok {
+			t.Fatal("expected errs to close without an error once done fired")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WriteStream did not stop after done was closed")
+	}
+}
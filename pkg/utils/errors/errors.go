@@ -0,0 +1,17 @@
+package errors
+
+import "fmt"
+
+type Error struct {
+	msg string
+}
+
+func (e *Error) Error() string { return e.msg }
+
+func New(msg string) error { return &Error{msg: msg} }
+
+func Trace(err error) error { return err }
+
+func Errorf(format string, args ...interface{}) error {
+	return &Error{msg: fmt.Sprintf(format, args...)}
+}